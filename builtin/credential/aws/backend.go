@@ -0,0 +1,85 @@
+package aws
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// Factory returns a configured instance of the backend, per the
+// logical.Factory signature the Vault core plugin catalog expects.
+func Factory(conf *logical.BackendConfig) (logical.Backend, error) {
+	b := Backend()
+	if err := b.Setup(conf); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// backend implements the AWS auth method: logging in as an IAM principal
+// (auth_type=iam) in exchange for a Vault token, bound by role to a set of
+// principals and policies. A login with auth_type=ec2 is rejected.
+type backend struct {
+	*framework.Backend
+
+	// configMutex guards EC2ClientsMap and serializes config/client and
+	// config/client/region/<region> writes against reads of the same, so
+	// that a credential change is never applied to only half of an EC2
+	// client's configuration. See the comments on flushCachedEC2Clients and
+	// clientEC2 for the locking convention cached client construction
+	// follows.
+	configMutex   sync.RWMutex
+	EC2ClientsMap map[string]*ec2.EC2
+
+	// vaultAWSEngineCacheMutex guards VaultAWSEngineCredsCache independently
+	// of configMutex, so that reading a cached vault_aws_engine credential -
+	// which may include a synchronous call to the Vault API - never blocks
+	// on, or blocks, unrelated config/client access.
+	vaultAWSEngineCacheMutex sync.Mutex
+	VaultAWSEngineCredsCache map[string]*vaultAWSEngineCreds
+}
+
+// Backend constructs the unconfigured backend, wiring up all paths this auth
+// method exposes.
+func Backend() *backend {
+	b := new(backend)
+
+	b.Backend = &framework.Backend{
+		Help: backendHelp,
+
+		PathsSpecial: &logical.Paths{
+			Unauthenticated: []string{"login"},
+		},
+
+		Paths: []*framework.Path{
+			pathLogin(b),
+			pathRole(b),
+			pathListRoles(b),
+			pathConfigClient(b),
+			pathConfigClientRegion(b),
+			pathListConfigClientRegions(b),
+		},
+
+		PeriodicFunc: b.periodicFunc,
+	}
+
+	return b
+}
+
+// periodicFunc evicts any cached vault_aws_engine credentials whose lease
+// has expired, so a long-idle mount doesn't hold stale cached credentials
+// until its next login request happens to trigger clientEC2.
+func (b *backend) periodicFunc(req *logical.Request) error {
+	b.evictExpiredVaultAWSEngineCreds()
+	return nil
+}
+
+const backendHelp = `
+The AWS auth backend allows workloads to authenticate with Vault using AWS
+identity: either a pre-signed sts:GetCallerIdentity request from any IAM
+principal (auth_type=iam), or a signed EC2 instance identity document
+(auth_type=ec2, not yet implemented). A successful login is matched against
+a role's bound principals and granted that role's policies.
+`
@@ -0,0 +1,445 @@
+package aws
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/logical"
+)
+
+// vaultAWSEngineRenewBuffer is how far ahead of lease expiration cached
+// vault_aws_engine credentials are treated as expired, so that a fresh STS
+// credential is fetched before the old one actually stops working.
+const vaultAWSEngineRenewBuffer = 2 * time.Minute
+
+// vaultAWSEngineCreds is a cache entry for credentials obtained from a Vault
+// AWS secrets engine mount on behalf of the auth backend itself.
+type vaultAWSEngineCreds struct {
+	creds      *credentials.Credentials
+	leaseID    string
+	expiration time.Time
+}
+
+// flushCachedEC2Clients deletes all the cached EC2 client objects from the
+// backend. If the client credentials have changed, this is invoked so that
+// the next request to the backend creates a new client with the new
+// credentials. Callers must already hold b.configMutex for write, the same
+// convention flushCachedEC2ClientsForRegion follows.
+func (b *backend) flushCachedEC2Clients() {
+	for region := range b.EC2ClientsMap {
+		delete(b.EC2ClientsMap, region)
+	}
+
+	b.vaultAWSEngineCacheMutex.Lock()
+	defer b.vaultAWSEngineCacheMutex.Unlock()
+	for key := range b.VaultAWSEngineCredsCache {
+		delete(b.VaultAWSEngineCredsCache, key)
+	}
+}
+
+// evictExpiredVaultAWSEngineCreds drops any vault_aws_engine credentials that
+// have expired (or are about to) from the cache. Any EC2 client built from an
+// evicted credential is flushed too, forcing clientEC2 to fetch a fresh STS
+// credential on next use. Called on every clientEC2 invocation so that a
+// lease expiring between logins doesn't leave a stale EC2 client cached
+// indefinitely; VaultAWSEngineCredsCache is guarded by its own mutex so this
+// can run ahead of taking b.configMutex for write.
+func (b *backend) evictExpiredVaultAWSEngineCreds() {
+	b.vaultAWSEngineCacheMutex.Lock()
+	now := time.Now()
+	expired := false
+	for key, cached := range b.VaultAWSEngineCredsCache {
+		if now.Before(cached.expiration.Add(-vaultAWSEngineRenewBuffer)) {
+			continue
+		}
+		delete(b.VaultAWSEngineCredsCache, key)
+		expired = true
+	}
+	b.vaultAWSEngineCacheMutex.Unlock()
+
+	if !expired {
+		return
+	}
+
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
+	for region := range b.EC2ClientsMap {
+		delete(b.EC2ClientsMap, region)
+	}
+}
+
+// flushCachedEC2ClientsForRegion evicts only the cached client for the given
+// region, used when a config/client/region/<region> entry changes.
+func (b *backend) flushCachedEC2ClientsForRegion(region string) {
+	delete(b.EC2ClientsMap, region)
+}
+
+// clientEC2 creates a client for the EC2 API. It uses a cached client if one
+// is available, using the region as the cache key. The region-specific
+// override registered at config/client/region/<region> takes precedence over
+// config/client. If there is no override and auto_discover_regions is enabled
+// on config/client, the region is added to the cache as a side effect of
+// discoverRegions; if neither entry is present, errRegionNotConfigured is
+// returned.
+//
+// Building the aws.Config, including any vault_aws_engine round trip to
+// Vault, is done without holding b.configMutex, so a slow or unreachable AWS
+// secrets engine only stalls logins for the region being built rather than
+// every region behind one exclusive lock.
+func (b *backend) clientEC2(s logical.Storage, region string) (*ec2.EC2, error) {
+	b.evictExpiredVaultAWSEngineCreds()
+
+	b.configMutex.RLock()
+	if b.EC2ClientsMap[region] != nil {
+		defer b.configMutex.RUnlock()
+		return b.EC2ClientsMap[region], nil
+	}
+	b.configMutex.RUnlock()
+
+	primaryConfig, err := b.clientConfigEntry(s)
+	if err != nil {
+		return nil, err
+	}
+
+	regionConfig, err := b.clientConfigEntryForRegion(s, region)
+	if err != nil {
+		return nil, err
+	}
+
+	if regionConfig == nil && primaryConfig != nil && primaryConfig.AutoDiscoverRegions {
+		if err := b.discoverRegions(s, primaryConfig); err != nil {
+			return nil, err
+		}
+		b.configMutex.RLock()
+		if b.EC2ClientsMap[region] != nil {
+			defer b.configMutex.RUnlock()
+			return b.EC2ClientsMap[region], nil
+		}
+		b.configMutex.RUnlock()
+	}
+
+	if regionConfig == nil && primaryConfig == nil {
+		return nil, errRegionNotConfigured
+	}
+
+	awsConfig, err := b.awsConfigForRegion(region, primaryConfig, regionConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := b.newEC2Client(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
+	if b.EC2ClientsMap == nil {
+		b.EC2ClientsMap = make(map[string]*ec2.EC2)
+	}
+	if cached := b.EC2ClientsMap[region]; cached != nil {
+		return cached, nil
+	}
+	b.EC2ClientsMap[region] = client
+	return client, nil
+}
+
+// discoverRegions calls EC2 DescribeRegions using the primary client
+// configuration and caches an EC2 client for every region returned, built
+// from that same configuration merged with the region's own
+// config/client/region/<region> override, if any. It is only invoked when
+// auto_discover_regions is set and no region-specific override exists for
+// the region a login was received for. The AWS API calls run without
+// b.configMutex held; only the resulting map update takes the lock.
+func (b *backend) discoverRegions(s logical.Storage, primaryConfig *clientConfig) error {
+	awsConfig, err := b.awsConfigForRegion("us-east-1", primaryConfig, nil)
+	if err != nil {
+		return err
+	}
+
+	discoveryClient, err := b.newEC2Client(awsConfig)
+	if err != nil {
+		return err
+	}
+
+	output, err := discoveryClient.DescribeRegions(&ec2.DescribeRegionsInput{})
+	if err != nil {
+		return fmt.Errorf("failed to auto discover regions: %v", err)
+	}
+
+	discovered := make(map[string]*ec2.EC2, len(output.Regions))
+	for _, r := range output.Regions {
+		regionName := aws.StringValue(r.RegionName)
+		regionConfig, err := b.clientConfigEntryForRegion(s, regionName)
+		if err != nil {
+			return err
+		}
+		regionAWSConfig, err := b.awsConfigForRegion(regionName, primaryConfig, regionConfig)
+		if err != nil {
+			return err
+		}
+		client, err := b.newEC2Client(regionAWSConfig)
+		if err != nil {
+			return err
+		}
+		discovered[regionName] = client
+	}
+
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
+	if b.EC2ClientsMap == nil {
+		b.EC2ClientsMap = make(map[string]*ec2.EC2)
+	}
+	for regionName, client := range discovered {
+		if b.EC2ClientsMap[regionName] == nil {
+			b.EC2ClientsMap[regionName] = client
+		}
+	}
+
+	return nil
+}
+
+func (b *backend) newEC2Client(awsConfig *aws.Config) (*ec2.EC2, error) {
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not establish a session to AWS: %v", err)
+	}
+
+	client := ec2.New(sess)
+	if client == nil {
+		return nil, fmt.Errorf("could not obtain ec2 client")
+	}
+	return client, nil
+}
+
+// awsConfigForRegion merges the region-specific override, if any, on top of
+// the primary config/client entry and builds the resulting *aws.Config.
+func (b *backend) awsConfigForRegion(region string, primaryConfig *clientConfig, regionConfig *regionClientConfig) (*aws.Config, error) {
+	merged := &clientConfig{}
+	if primaryConfig != nil {
+		*merged = *primaryConfig
+	}
+	if regionConfig != nil {
+		if regionConfig.AccessKey != "" {
+			merged.AccessKey = regionConfig.AccessKey
+		}
+		if regionConfig.SecretKey != "" {
+			merged.SecretKey = regionConfig.SecretKey
+		}
+		if regionConfig.Endpoint != "" {
+			merged.Endpoint = regionConfig.Endpoint
+		}
+	}
+
+	return b.awsConfig(region, merged)
+}
+
+// awsConfig builds an *aws.Config for the given region, honoring the
+// credential_source selected in the client configuration, and layering an
+// STS AssumeRole call on top when role_arn is set.
+func (b *backend) awsConfig(region string, clientConfig *clientConfig) (*aws.Config, error) {
+	awsConfig := aws.NewConfig().
+		WithMaxRetries(aws.DefaultRetries).
+		WithRegion(region)
+
+	if clientConfig != nil && clientConfig.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(clientConfig.Endpoint)
+	}
+
+	if clientConfig != nil && clientConfig.CredentialSource == "vault_aws_engine" {
+		creds, err := b.vaultAWSEngineCredentials(clientConfig)
+		if err != nil {
+			return nil, err
+		}
+		awsConfig = awsConfig.WithCredentials(creds)
+	} else {
+		providers, err := b.credentialProviders(clientConfig)
+		if err != nil {
+			return nil, err
+		}
+		if len(providers) > 0 {
+			awsConfig = awsConfig.WithCredentials(credentials.NewChainCredentials(providers))
+		}
+	}
+
+	if clientConfig != nil && clientConfig.RoleARN != "" {
+		baseSession, err := session.NewSession(awsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not establish a session to AWS: %v", err)
+		}
+
+		ttl := clientConfig.SessionTTL
+		if ttl <= 0 {
+			ttl = 3600
+		}
+		assumeRoleProvider := &stscreds.AssumeRoleProvider{
+			Client:          sts.New(baseSession),
+			RoleARN:         clientConfig.RoleARN,
+			RoleSessionName: clientConfig.RoleSessionName,
+			Duration:        time.Duration(ttl) * time.Second,
+		}
+		if clientConfig.ExternalID != "" {
+			assumeRoleProvider.ExternalID = aws.String(clientConfig.ExternalID)
+		}
+		if clientConfig.MFASerial != "" {
+			assumeRoleProvider.SerialNumber = aws.String(clientConfig.MFASerial)
+			assumeRoleProvider.TokenProvider = mfaTokenProvider
+		}
+
+		awsConfig = awsConfig.WithCredentials(credentials.NewCredentials(assumeRoleProvider))
+	}
+
+	return awsConfig, nil
+}
+
+// credentialProviders returns the chain of credential providers to use based
+// on the configured credential_source, in the order they should be tried.
+func (b *backend) credentialProviders(clientConfig *clientConfig) ([]credentials.Provider, error) {
+	source := "static"
+	if clientConfig != nil && clientConfig.CredentialSource != "" {
+		source = clientConfig.CredentialSource
+	}
+
+	switch source {
+	case "static":
+		if clientConfig == nil || clientConfig.AccessKey == "" {
+			return nil, nil
+		}
+		return []credentials.Provider{
+			&credentials.StaticProvider{
+				Value: credentials.Value{
+					AccessKeyID:     clientConfig.AccessKey,
+					SecretAccessKey: clientConfig.SecretKey,
+				},
+			},
+		}, nil
+	case "env":
+		return []credentials.Provider{&credentials.EnvProvider{}}, nil
+	case "ec2_instance_metadata":
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, fmt.Errorf("could not establish a session to AWS: %v", err)
+		}
+		return []credentials.Provider{
+			&ec2rolecreds.EC2RoleProvider{
+				Client: ec2metadata.New(sess),
+			},
+		}, nil
+	case "ecs_container":
+		uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+		if uri == "" {
+			return nil, fmt.Errorf("credential_source is ecs_container but AWS_CONTAINER_CREDENTIALS_RELATIVE_URI is not set")
+		}
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, fmt.Errorf("could not establish a session to AWS: %v", err)
+		}
+		return []credentials.Provider{
+			endpointcreds.NewProviderClient(*sess.Config, nil, "http://169.254.170.2"+uri),
+		}, nil
+	case "assume_role":
+		// Credentials are entirely derived from the AssumeRole call set up in
+		// awsConfig; no base provider is required here.
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported credential_source %q", source)
+	}
+}
+
+// vaultAWSEngineCredentials returns short-lived AWS credentials read from the
+// Vault AWS secrets engine mount configured via vault_aws_engine_mount and
+// vault_aws_role, reusing a cached credential until shortly before its lease
+// expires. VaultAWSEngineCredsCache is guarded by its own mutex, independent
+// of b.configMutex, so that the Vault API round trip below never blocks an
+// EC2 client lookup for another region.
+func (b *backend) vaultAWSEngineCredentials(clientConfig *clientConfig) (*credentials.Credentials, error) {
+	if clientConfig.VaultAWSEngineMount == "" || clientConfig.VaultAWSRole == "" {
+		return nil, fmt.Errorf("vault_aws_engine_mount and vault_aws_role must both be set when credential_source is vault_aws_engine")
+	}
+
+	cacheKey := strings.Trim(clientConfig.VaultAWSEngineMount, "/") + "/" + clientConfig.VaultAWSRole
+
+	b.vaultAWSEngineCacheMutex.Lock()
+	cached := b.VaultAWSEngineCredsCache[cacheKey]
+	b.vaultAWSEngineCacheMutex.Unlock()
+	if cached != nil && time.Now().Before(cached.expiration.Add(-vaultAWSEngineRenewBuffer)) {
+		return cached.creds, nil
+	}
+
+	apiConfig := api.DefaultConfig()
+	if err := apiConfig.ReadEnvironment(); err != nil {
+		return nil, fmt.Errorf("failed to read Vault API environment: %v", err)
+	}
+
+	apiClient, err := api.NewClient(apiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Vault API client for vault_aws_engine: %v", err)
+	}
+	apiClient.SetToken(clientConfig.VaultToken)
+
+	readPath := fmt.Sprintf("%s/creds/%s", strings.Trim(clientConfig.VaultAWSEngineMount, "/"), clientConfig.VaultAWSRole)
+
+	var secret *api.Secret
+	if clientConfig.VaultAWSTTL > 0 {
+		secret, err = apiClient.Logical().ReadWithData(readPath, map[string][]string{
+			"ttl": {fmt.Sprintf("%ds", clientConfig.VaultAWSTTL)},
+		})
+	} else {
+		secret, err = apiClient.Logical().Read(readPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials from %s: %v", readPath, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("no credentials returned from %s", readPath)
+	}
+
+	accessKey, _ := secret.Data["access_key"].(string)
+	secretKey, _ := secret.Data["secret_key"].(string)
+	securityToken, _ := secret.Data["security_token"].(string)
+
+	creds := credentials.NewStaticCredentials(accessKey, secretKey, securityToken)
+
+	leaseDuration := time.Duration(secret.LeaseDuration) * time.Second
+	if leaseDuration == 0 {
+		leaseDuration = time.Hour
+	}
+
+	b.vaultAWSEngineCacheMutex.Lock()
+	defer b.vaultAWSEngineCacheMutex.Unlock()
+	if b.VaultAWSEngineCredsCache == nil {
+		b.VaultAWSEngineCredsCache = make(map[string]*vaultAWSEngineCreds)
+	}
+	b.VaultAWSEngineCredsCache[cacheKey] = &vaultAWSEngineCreds{
+		creds:      creds,
+		leaseID:    secret.LeaseID,
+		expiration: time.Now().Add(leaseDuration),
+	}
+
+	return creds, nil
+}
+
+// mfaTokenProvider is installed as the AssumeRoleProvider's TokenProvider
+// when mfa_serial is configured on config/client. clientEC2 runs inline with
+// live requests to this backend (most notably logins), so a TokenProvider
+// that blocks reading an MFA code from the Vault server process's own stdin
+// would hang the request indefinitely waiting on input nothing is providing.
+// There is no per-request channel back to whoever holds the MFA device, so
+// this can only fail clearly rather than prompt.
+func mfaTokenProvider() (string, error) {
+	return "", fmt.Errorf("mfa_serial is configured on config/client, but this backend has no way to " +
+		"collect an MFA token code for a role_arn AssumeRole call made while servicing a request; " +
+		"remove mfa_serial, which in turn requires a role_arn that does not require MFA")
+}
@@ -0,0 +1,201 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestAwsConfigForRegionMergePrecedence(t *testing.T) {
+	b := &backend{}
+
+	primary := &clientConfig{
+		AccessKey: "primary-access",
+		SecretKey: "primary-secret",
+		Endpoint:  "",
+	}
+	region := &regionClientConfig{
+		Region:    "us-west-2",
+		AccessKey: "",
+		SecretKey: "region-secret",
+		Endpoint:  "https://ec2.us-west-2.example.com",
+	}
+
+	awsConfig, err := b.awsConfigForRegion("us-west-2", primary, region)
+	if err != nil {
+		t.Fatalf("awsConfigForRegion returned an error: %v", err)
+	}
+
+	if got := *awsConfig.Endpoint; got != region.Endpoint {
+		t.Errorf("endpoint = %q, want region override %q", got, region.Endpoint)
+	}
+
+	creds, err := awsConfig.Credentials.Get()
+	if err != nil {
+		t.Fatalf("Credentials.Get() returned an error: %v", err)
+	}
+	if creds.AccessKeyID != primary.AccessKey {
+		t.Errorf("access key = %q, want primary value %q since the region left it unset", creds.AccessKeyID, primary.AccessKey)
+	}
+	if creds.SecretAccessKey != region.SecretKey {
+		t.Errorf("secret key = %q, want region override %q", creds.SecretAccessKey, region.SecretKey)
+	}
+}
+
+func TestAwsConfigForRegionNoOverride(t *testing.T) {
+	b := &backend{}
+
+	primary := &clientConfig{
+		AccessKey: "primary-access",
+		SecretKey: "primary-secret",
+		Endpoint:  "https://ec2.example.com",
+	}
+
+	awsConfig, err := b.awsConfigForRegion("us-east-1", primary, nil)
+	if err != nil {
+		t.Fatalf("awsConfigForRegion returned an error: %v", err)
+	}
+
+	if got := *awsConfig.Endpoint; got != primary.Endpoint {
+		t.Errorf("endpoint = %q, want primary value %q", got, primary.Endpoint)
+	}
+}
+
+func TestCredentialProvidersStatic(t *testing.T) {
+	b := &backend{}
+
+	config := &clientConfig{
+		CredentialSource: "static",
+		AccessKey:        "AKIAEXAMPLE",
+		SecretKey:        "examplesecret",
+	}
+	providers, err := b.credentialProviders(config)
+	if err != nil {
+		t.Fatalf("credentialProviders returned an error: %v", err)
+	}
+	if len(providers) != 1 {
+		t.Fatalf("got %d providers, want 1", len(providers))
+	}
+	value, err := providers[0].Retrieve()
+	if err != nil {
+		t.Fatalf("Retrieve returned an error: %v", err)
+	}
+	if value.AccessKeyID != config.AccessKey || value.SecretAccessKey != config.SecretKey {
+		t.Errorf("got access key %q / secret key %q, want %q / %q", value.AccessKeyID, value.SecretAccessKey, config.AccessKey, config.SecretKey)
+	}
+}
+
+func TestCredentialProvidersStaticWithNoAccessKeyIsEmpty(t *testing.T) {
+	b := &backend{}
+
+	providers, err := b.credentialProviders(&clientConfig{CredentialSource: "static"})
+	if err != nil {
+		t.Fatalf("credentialProviders returned an error: %v", err)
+	}
+	if len(providers) != 0 {
+		t.Errorf("got %d providers, want 0 when no access_key is configured", len(providers))
+	}
+}
+
+func TestCredentialProvidersAssumeRoleHasNoBaseProvider(t *testing.T) {
+	b := &backend{}
+
+	// assume_role's credentials come entirely from the AssumeRoleProvider
+	// awsConfig layers on top, not from a base provider in this chain.
+	providers, err := b.credentialProviders(&clientConfig{CredentialSource: "assume_role"})
+	if err != nil {
+		t.Fatalf("credentialProviders returned an error: %v", err)
+	}
+	if len(providers) != 0 {
+		t.Errorf("got %d providers, want 0 for assume_role", len(providers))
+	}
+}
+
+func TestCredentialProvidersUnsupportedSource(t *testing.T) {
+	b := &backend{}
+
+	if _, err := b.credentialProviders(&clientConfig{CredentialSource: "bogus"}); err == nil {
+		t.Error("expected an unsupported credential_source to return an error")
+	}
+}
+
+func TestAwsConfigAssumeRoleWithMFASerialInstallsFailingTokenProvider(t *testing.T) {
+	b := &backend{}
+
+	config := &clientConfig{
+		AccessKey: "AKIAEXAMPLE",
+		SecretKey: "examplesecret",
+		RoleARN:   "arn:aws:iam::123456789012:role/example",
+		MFASerial: "arn:aws:iam::123456789012:mfa/example-user",
+	}
+
+	awsConfig, err := b.awsConfig("us-east-1", config)
+	if err != nil {
+		t.Fatalf("awsConfig returned an error: %v", err)
+	}
+	if awsConfig.Credentials == nil {
+		t.Fatal("expected awsConfig to have AssumeRole credentials configured")
+	}
+
+	// mfaTokenProvider cannot actually collect a token code on the server
+	// servicing this request; confirm it fails immediately rather than
+	// blocking, which is what an operator would hit the moment the
+	// AssumeRoleProvider tries to call it.
+	if _, err := mfaTokenProvider(); err == nil {
+		t.Error("expected mfaTokenProvider to return an error")
+	}
+}
+
+func TestVaultAWSEngineCredentialsReturnsCachedCredsWithoutContactingVault(t *testing.T) {
+	b := &backend{
+		VaultAWSEngineCredsCache: map[string]*vaultAWSEngineCreds{
+			"aws/example-role": {
+				creds:      credentials.NewStaticCredentials("cached-access", "cached-secret", ""),
+				expiration: time.Now().Add(time.Hour),
+			},
+		},
+	}
+
+	config := &clientConfig{
+		VaultAWSEngineMount: "aws/",
+		VaultAWSRole:        "example-role",
+	}
+
+	creds, err := b.vaultAWSEngineCredentials(config)
+	if err != nil {
+		t.Fatalf("vaultAWSEngineCredentials returned an error: %v", err)
+	}
+	value, err := creds.Get()
+	if err != nil {
+		t.Fatalf("Get returned an error: %v", err)
+	}
+	if value.AccessKeyID != "cached-access" {
+		t.Errorf("access key = %q, want the cached value", value.AccessKeyID)
+	}
+}
+
+func TestEvictExpiredVaultAWSEngineCredsDropsOnlyExpiredEntries(t *testing.T) {
+	b := &backend{
+		EC2ClientsMap: map[string]*ec2.EC2{
+			"us-east-1": {},
+		},
+		VaultAWSEngineCredsCache: map[string]*vaultAWSEngineCreds{
+			"aws/expired-role": {expiration: time.Now().Add(-time.Hour)},
+			"aws/current-role": {expiration: time.Now().Add(time.Hour)},
+		},
+	}
+
+	b.evictExpiredVaultAWSEngineCreds()
+
+	if _, ok := b.VaultAWSEngineCredsCache["aws/expired-role"]; ok {
+		t.Error("expected the expired cache entry to have been evicted")
+	}
+	if _, ok := b.VaultAWSEngineCredsCache["aws/current-role"]; !ok {
+		t.Error("expected the still-current cache entry to remain cached")
+	}
+	if len(b.EC2ClientsMap) != 0 {
+		t.Error("expected cached EC2 clients to be flushed once any vault_aws_engine credential expired")
+	}
+}
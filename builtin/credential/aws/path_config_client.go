@@ -6,6 +6,12 @@ import (
 	"github.com/hashicorp/vault/logical/framework"
 )
 
+// defaultAllowedSTSEndpoints is the allowed_sts_endpoints value assumed when
+// an operator has never set one, so that a freshly configured backend
+// forwards sts:GetCallerIdentity requests only to real AWS STS endpoints
+// rather than any host a caller names.
+var defaultAllowedSTSEndpoints = []string{"sts.amazonaws.com"}
+
 func pathConfigClient(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "config/client$",
@@ -27,6 +33,105 @@ func pathConfigClient(b *backend) *framework.Path {
 				Default:     "",
 				Description: "URL to override the default generated endpoint for making AWS EC2 API calls.",
 			},
+
+			"credential_source": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "static",
+				Description: `Source of the credentials used to make AWS EC2 API calls. One of
+"static" (use access_key/secret_key), "env" (AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY
+environment variables), "ec2_instance_metadata" (EC2 instance profile), "ecs_container"
+(ECS task role via AWS_CONTAINER_CREDENTIALS_RELATIVE_URI), "vault_aws_engine" (dynamic
+STS credentials read from a Vault AWS secrets engine mount, see vault_aws_engine_mount)
+or "assume_role" (STS AssumeRole, optionally layered on top of any of the above).`,
+			},
+
+			"role_arn": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "ARN of the IAM role to assume via STS AssumeRole before making AWS EC2 API calls.",
+			},
+
+			"role_session_name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "vault-aws-auth",
+				Description: "Session name to use when assuming role_arn via STS AssumeRole.",
+			},
+
+			"external_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "External ID to pass along with the STS AssumeRole request for role_arn.",
+			},
+
+			"mfa_serial": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `Serial number (or ARN) of the MFA device to use when assuming role_arn.
+Since this backend has no way to collect an MFA token code while servicing a request, setting this
+causes every AssumeRole call for role_arn to fail, whether or not role_arn itself requires MFA.`,
+			},
+
+			"session_ttl": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Default:     3600,
+				Description: "Duration in seconds of the STS session obtained via assume_role. Defaults to 3600 (1 hour).",
+			},
+
+			"auto_discover_regions": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, and a login is received for a region that has no
+config/client/region/<region> override and no cached client, the backend calls EC2
+DescribeRegions using the credentials configured here and caches a client for every
+region returned. If unset, a client for such a region is built from this entry alone
+on first use.`,
+			},
+
+			"vault_aws_engine_mount": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `Mount path of a Vault AWS secrets engine (e.g. "aws/") that this
+backend should read dynamic credentials from, instead of using access_key/secret_key directly.
+Requires vault_aws_role and vault_token to also be set.`,
+			},
+
+			"vault_aws_role": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "Role in the vault_aws_engine_mount secrets engine to read credentials from.",
+			},
+
+			"vault_aws_ttl": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Default:     0,
+				Description: "TTL in seconds to request for credentials read from vault_aws_engine_mount. Defaults to the role's configured TTL.",
+			},
+
+			"vault_token": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "Vault token with permission to read creds from vault_aws_engine_mount/creds/vault_aws_role.",
+			},
+
+			"iam_server_id_header_value": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `Value to require in the X-Vault-AWS-IAM-Server-ID header of the
+sts:GetCallerIdentity request presented to login with auth_type=iam, to mitigate replay of a
+signed request against a different Vault server. Corresponds to the value passed to
+AWSAuthClient.buildServerIdHeader() on the client side.`,
+			},
+
+			"allowed_sts_endpoints": &framework.FieldSchema{
+				Type:    framework.TypeCommaStringSlice,
+				Default: defaultAllowedSTSEndpoints,
+				Description: `Comma-separated list of STS endpoint hostnames (e.g. "sts.amazonaws.com",
+"sts.us-gov-west-1.amazonaws.com") that a login with auth_type=iam is allowed to forward its
+sts:GetCallerIdentity request to. Defaults to the global AWS STS endpoint, sts.amazonaws.com; set to
+additional regional hostnames for GovCloud/China deployments, or explicitly to an empty list to
+allow any endpoint (not recommended - this lets a caller direct the backend's outbound request
+at an arbitrary host).`,
+			},
 		},
 
 		ExistenceCheck: b.pathConfigClientExistenceCheck,
@@ -77,9 +182,111 @@ func (b *backend) clientConfigEntryInternal(s logical.Storage) (*clientConfig, e
 	if err := entry.DecodeJSON(&result); err != nil {
 		return nil, err
 	}
+
+	// Older versions of this entry stored secret_key inline, in plaintext.
+	// Migrate it out to the seal-wrapped entry on first read so it is no
+	// longer present in the plaintext config/client blob.
+	var legacy legacyClientConfig
+	if err := entry.DecodeJSON(&legacy); err != nil {
+		return nil, err
+	}
+	if legacy.SecretKey != "" || legacy.VaultToken != "" {
+		if legacy.SecretKey != "" {
+			if err := b.storeSecretKey(s, legacy.SecretKey); err != nil {
+				return nil, err
+			}
+			result.SecretKey = legacy.SecretKey
+		}
+		if legacy.VaultToken != "" {
+			if err := b.storeVaultToken(s, legacy.VaultToken); err != nil {
+				return nil, err
+			}
+			result.VaultToken = legacy.VaultToken
+		}
+		entry, err := logical.StorageEntryJSON("config/client", &result)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Put(entry); err != nil {
+			return nil, err
+		}
+		return &result, nil
+	}
+
+	secretKey, err := b.loadSecretKey(s)
+	if err != nil {
+		return nil, err
+	}
+	result.SecretKey = secretKey
+
+	vaultToken, err := b.loadVaultToken(s)
+	if err != nil {
+		return nil, err
+	}
+	result.VaultToken = vaultToken
+
 	return &result, nil
 }
 
+// storeSecretKey writes secret_key through Vault's seal wrap storage path, so
+// that it is encrypted independent of the barrier key and at rest even to
+// someone with raw access to the physical storage backend.
+func (b *backend) storeSecretKey(s logical.Storage, secretKey string) error {
+	entry, err := logical.StorageEntryJSON("config/client/secret_key", &secretKeyEntry{SecretKey: secretKey})
+	if err != nil {
+		return err
+	}
+	entry.SealWrap = true
+	return s.Put(entry)
+}
+
+// loadSecretKey reads back the seal-wrapped secret_key written by
+// storeSecretKey. Returns "" if none has been stored.
+func (b *backend) loadSecretKey(s logical.Storage) (string, error) {
+	entry, err := s.Get("config/client/secret_key")
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", nil
+	}
+
+	var result secretKeyEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return "", err
+	}
+	return result.SecretKey, nil
+}
+
+// storeVaultToken writes vault_token through Vault's seal wrap storage path,
+// for the same reason and in the same manner as storeSecretKey.
+func (b *backend) storeVaultToken(s logical.Storage, vaultToken string) error {
+	entry, err := logical.StorageEntryJSON("config/client/vault_token", &vaultTokenEntry{VaultToken: vaultToken})
+	if err != nil {
+		return err
+	}
+	entry.SealWrap = true
+	return s.Put(entry)
+}
+
+// loadVaultToken reads back the seal-wrapped vault_token written by
+// storeVaultToken. Returns "" if none has been stored.
+func (b *backend) loadVaultToken(s logical.Storage) (string, error) {
+	entry, err := s.Get("config/client/vault_token")
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", nil
+	}
+
+	var result vaultTokenEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return "", err
+	}
+	return result.VaultToken, nil
+}
+
 func (b *backend) pathConfigClientRead(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	clientConfig, err := b.clientConfigEntry(req.Storage)
@@ -91,8 +298,19 @@ func (b *backend) pathConfigClientRead(
 		return nil, nil
 	}
 
+	// secret_key and vault_token are never returned in plaintext, even to a
+	// Read of config/client; a fixed sentinel confirms each is set without
+	// disclosing its value.
+	redacted := *clientConfig
+	if redacted.SecretKey != "" {
+		redacted.SecretKey = "<sensitive>"
+	}
+	if redacted.VaultToken != "" {
+		redacted.VaultToken = "<sensitive>"
+	}
+
 	return &logical.Response{
-		Data: structs.New(clientConfig).Map(),
+		Data: structs.New(&redacted).Map(),
 	}, nil
 }
 
@@ -104,6 +322,12 @@ func (b *backend) pathConfigClientDelete(
 	if err := req.Storage.Delete("config/client"); err != nil {
 		return nil, err
 	}
+	if err := req.Storage.Delete("config/client/secret_key"); err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete("config/client/vault_token"); err != nil {
+		return nil, err
+	}
 
 	// Remove all the cached EC2 client objects in the backend.
 	b.flushCachedEC2Clients()
@@ -159,11 +383,126 @@ func (b *backend) pathConfigClientCreateUpdate(
 		configEntry.Endpoint = data.Get("endpoint").(string)
 	}
 
+	credentialSourceStr, ok := data.GetOk("credential_source")
+	if ok {
+		if configEntry.CredentialSource != credentialSourceStr.(string) {
+			changedCreds = true
+			configEntry.CredentialSource = credentialSourceStr.(string)
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.CredentialSource = data.Get("credential_source").(string)
+	}
+
+	roleARNStr, ok := data.GetOk("role_arn")
+	if ok {
+		if configEntry.RoleARN != roleARNStr.(string) {
+			changedCreds = true
+			configEntry.RoleARN = roleARNStr.(string)
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.RoleARN = data.Get("role_arn").(string)
+	}
+
+	roleSessionNameStr, ok := data.GetOk("role_session_name")
+	if ok {
+		configEntry.RoleSessionName = roleSessionNameStr.(string)
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.RoleSessionName = data.Get("role_session_name").(string)
+	}
+
+	externalIDStr, ok := data.GetOk("external_id")
+	if ok {
+		if configEntry.ExternalID != externalIDStr.(string) {
+			changedCreds = true
+			configEntry.ExternalID = externalIDStr.(string)
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.ExternalID = data.Get("external_id").(string)
+	}
+
+	mfaSerialStr, ok := data.GetOk("mfa_serial")
+	if ok {
+		configEntry.MFASerial = mfaSerialStr.(string)
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.MFASerial = data.Get("mfa_serial").(string)
+	}
+
+	sessionTTLInt, ok := data.GetOk("session_ttl")
+	if ok {
+		configEntry.SessionTTL = sessionTTLInt.(int)
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.SessionTTL = data.Get("session_ttl").(int)
+	}
+
+	autoDiscoverRegionsBool, ok := data.GetOk("auto_discover_regions")
+	if ok {
+		configEntry.AutoDiscoverRegions = autoDiscoverRegionsBool.(bool)
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.AutoDiscoverRegions = data.Get("auto_discover_regions").(bool)
+	}
+
+	vaultAWSEngineMountStr, ok := data.GetOk("vault_aws_engine_mount")
+	if ok {
+		if configEntry.VaultAWSEngineMount != vaultAWSEngineMountStr.(string) {
+			changedCreds = true
+			configEntry.VaultAWSEngineMount = vaultAWSEngineMountStr.(string)
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.VaultAWSEngineMount = data.Get("vault_aws_engine_mount").(string)
+	}
+
+	vaultAWSRoleStr, ok := data.GetOk("vault_aws_role")
+	if ok {
+		if configEntry.VaultAWSRole != vaultAWSRoleStr.(string) {
+			changedCreds = true
+			configEntry.VaultAWSRole = vaultAWSRoleStr.(string)
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.VaultAWSRole = data.Get("vault_aws_role").(string)
+	}
+
+	vaultAWSTTLInt, ok := data.GetOk("vault_aws_ttl")
+	if ok {
+		configEntry.VaultAWSTTL = vaultAWSTTLInt.(int)
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.VaultAWSTTL = data.Get("vault_aws_ttl").(int)
+	}
+
+	vaultTokenStr, ok := data.GetOk("vault_token")
+	if ok {
+		if configEntry.VaultToken != vaultTokenStr.(string) {
+			changedCreds = true
+			configEntry.VaultToken = vaultTokenStr.(string)
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.VaultToken = data.Get("vault_token").(string)
+	}
+
+	iamServerIDHeaderValueStr, ok := data.GetOk("iam_server_id_header_value")
+	if ok {
+		configEntry.IAMServerIDHeaderValue = iamServerIDHeaderValueStr.(string)
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.IAMServerIDHeaderValue = data.Get("iam_server_id_header_value").(string)
+	}
+
+	allowedSTSEndpointsRaw, ok := data.GetOk("allowed_sts_endpoints")
+	if ok {
+		configEntry.AllowedSTSEndpoints = allowedSTSEndpointsRaw.([]string)
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.AllowedSTSEndpoints = data.Get("allowed_sts_endpoints").([]string)
+	}
+
 	// Since this endpoint supports both create operation and update operation,
 	// the error checks for access_key and secret_key not being set are not present.
 	// This allows calling this endpoint multiple times to provide the values.
 	// Hence, the readers of this endpoint should do the validation on
 	// the validation of keys before using them.
+	//
+	// secret_key and vault_token are both excluded from this JSON blob (see
+	// their struct tags) and persisted separately through their own
+	// seal-wrapped entries, so that config/client stays plaintext for
+	// debuggability while the sensitive material is encrypted independent of
+	// the barrier key.
 	entry, err := logical.StorageEntryJSON("config/client", configEntry)
 	if err != nil {
 		return nil, err
@@ -173,6 +512,17 @@ func (b *backend) pathConfigClientCreateUpdate(
 		return nil, err
 	}
 
+	// Always rewrite both seal-wrapped entries, even when the corresponding
+	// configEntry field is empty, so that an update explicitly clearing
+	// secret_key or vault_token actually removes the previously stored value
+	// instead of leaving it in place.
+	if err := b.storeSecretKey(req.Storage, configEntry.SecretKey); err != nil {
+		return nil, err
+	}
+	if err := b.storeVaultToken(req.Storage, configEntry.VaultToken); err != nil {
+		return nil, err
+	}
+
 	if changedCreds {
 		b.flushCachedEC2Clients()
 	}
@@ -180,12 +530,78 @@ func (b *backend) pathConfigClientCreateUpdate(
 	return nil, nil
 }
 
+// legacyClientConfig decodes the secret_key and vault_token fields as they
+// were stored in config/client prior to seal wrap support, so that
+// clientConfigEntryInternal can migrate any pre-existing plaintext entries
+// the first time they are read.
+type legacyClientConfig struct {
+	SecretKey  string `json:"secret_key"`
+	VaultToken string `json:"vault_token"`
+}
+
+// secretKeyEntry is the payload of the seal-wrapped config/client/secret_key
+// storage entry.
+type secretKeyEntry struct {
+	SecretKey string `json:"secret_key"`
+}
+
+// vaultTokenEntry is the payload of the seal-wrapped config/client/vault_token
+// storage entry.
+type vaultTokenEntry struct {
+	VaultToken string `json:"vault_token"`
+}
+
 // Struct to hold 'aws_access_key' and 'aws_secret_key' that are required to
 // interact with the AWS EC2 API.
 type clientConfig struct {
 	AccessKey string `json:"access_key" structs:"access_key" mapstructure:"access_key"`
-	SecretKey string `json:"secret_key" structs:"secret_key" mapstructure:"secret_key"`
+
+	// SecretKey is deliberately excluded from config/client's own JSON
+	// marshalling (see storeSecretKey/loadSecretKey); it is populated here
+	// for the convenience of internal callers after being read back from its
+	// seal-wrapped entry.
+	SecretKey string `json:"-" structs:"secret_key" mapstructure:"secret_key"`
 	Endpoint  string `json:"endpoint" structs:"endpoint" mapstructure:"endpoint"`
+
+	// CredentialSource selects how the backend obtains the credentials used to
+	// call the AWS EC2 API: "static", "env", "ec2_instance_metadata",
+	// "ecs_container", "vault_aws_engine" or "assume_role". Defaults to "static" for backwards
+	// compatibility with access_key/secret_key based configuration.
+	CredentialSource string `json:"credential_source" structs:"credential_source" mapstructure:"credential_source"`
+
+	// The following fields are only used when CredentialSource is
+	// "assume_role", or when layering an AssumeRole call on top of any other
+	// credential source.
+	RoleARN         string `json:"role_arn" structs:"role_arn" mapstructure:"role_arn"`
+	RoleSessionName string `json:"role_session_name" structs:"role_session_name" mapstructure:"role_session_name"`
+	ExternalID      string `json:"external_id" structs:"external_id" mapstructure:"external_id"`
+	MFASerial       string `json:"mfa_serial" structs:"mfa_serial" mapstructure:"mfa_serial"`
+	SessionTTL      int    `json:"session_ttl" structs:"session_ttl" mapstructure:"session_ttl"`
+
+	// AutoDiscoverRegions, when true, has the backend call EC2 DescribeRegions
+	// on first use of a region with no config/client/region/<region> entry
+	// and cache a client for every region it returns. When false, such a
+	// region's client is built from config/client alone.
+	AutoDiscoverRegions bool `json:"auto_discover_regions" structs:"auto_discover_regions" mapstructure:"auto_discover_regions"`
+
+	// The following fields are only used when CredentialSource is
+	// "vault_aws_engine": instead of static access_key/secret_key, the
+	// backend reads short-lived STS credentials from a Vault AWS secrets
+	// engine mount on each EC2 client build.
+	VaultAWSEngineMount string `json:"vault_aws_engine_mount" structs:"vault_aws_engine_mount" mapstructure:"vault_aws_engine_mount"`
+	VaultAWSRole        string `json:"vault_aws_role" structs:"vault_aws_role" mapstructure:"vault_aws_role"`
+	VaultAWSTTL         int    `json:"vault_aws_ttl" structs:"vault_aws_ttl" mapstructure:"vault_aws_ttl"`
+
+	// VaultToken is deliberately excluded from config/client's own JSON
+	// marshalling (see storeVaultToken/loadVaultToken), for the same reason
+	// as SecretKey.
+	VaultToken string `json:"-" structs:"vault_token" mapstructure:"vault_token"`
+
+	// The following fields apply to the "iam" auth_type of the login path,
+	// where a client authenticates with a pre-signed sts:GetCallerIdentity
+	// request instead of an EC2 instance identity document.
+	IAMServerIDHeaderValue string   `json:"iam_server_id_header_value" structs:"iam_server_id_header_value" mapstructure:"iam_server_id_header_value"`
+	AllowedSTSEndpoints    []string `json:"allowed_sts_endpoints" structs:"allowed_sts_endpoints" mapstructure:"allowed_sts_endpoints"`
 }
 
 const pathConfigClientHelpSyn = `
@@ -194,6 +610,12 @@ Configure the client credentials that are used to query instance details from AW
 
 const pathConfigClientHelpDesc = `
 AWS auth backend makes DescribeInstances API call to retrieve information regarding
-the instance that performs login. The aws_secret_key and aws_access_key registered with Vault should have the
-permissions to make the API call.
+the instance that performs login. By default the aws_secret_key and aws_access_key
+registered with Vault are used to make this call, but credential_source can instead
+be set to "env", "ec2_instance_metadata" or "ecs_container" to pull credentials from
+the environment, from the instance's own EC2 instance profile, or from the ECS task
+role, so that no long-lived static keys need to be stored in Vault at all. Any of
+these sources can additionally be layered with an STS AssumeRole call by setting
+role_arn (and, for roles that require it, external_id). mfa_serial is accepted, but
+no MFA token code can be supplied, so with it set every AssumeRole call fails.
 `
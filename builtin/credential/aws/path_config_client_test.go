@@ -0,0 +1,140 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestClientConfigEntryInternalMigratesPlaintextSecretKey(t *testing.T) {
+	b := &backend{}
+	s := &logical.InmemStorage{}
+
+	legacy, err := logical.StorageEntryJSON("config/client", map[string]interface{}{
+		"access_key": "AKIAEXAMPLE",
+		"secret_key": "plaintext-secret",
+	})
+	if err != nil {
+		t.Fatalf("failed to build legacy storage entry: %v", err)
+	}
+	if err := s.Put(legacy); err != nil {
+		t.Fatalf("failed to write legacy storage entry: %v", err)
+	}
+
+	result, err := b.clientConfigEntryInternal(s)
+	if err != nil {
+		t.Fatalf("clientConfigEntryInternal returned an error: %v", err)
+	}
+	if result.SecretKey != "plaintext-secret" {
+		t.Errorf("SecretKey = %q, want the migrated legacy value", result.SecretKey)
+	}
+
+	// The plaintext config/client entry should no longer carry secret_key...
+	raw, err := s.Get("config/client")
+	if err != nil {
+		t.Fatalf("failed to re-read config/client: %v", err)
+	}
+	var onDisk map[string]interface{}
+	if err := raw.DecodeJSON(&onDisk); err != nil {
+		t.Fatalf("failed to decode config/client: %v", err)
+	}
+	if _, present := onDisk["secret_key"]; present {
+		t.Error("secret_key is still present in the plaintext config/client entry after migration")
+	}
+
+	// ...and it should now be readable back from the seal-wrapped entry.
+	sealWrapped, err := s.Get("config/client/secret_key")
+	if err != nil {
+		t.Fatalf("failed to read seal-wrapped secret_key entry: %v", err)
+	}
+	if sealWrapped == nil {
+		t.Fatal("expected a seal-wrapped config/client/secret_key entry to have been written")
+	}
+	if !sealWrapped.SealWrap {
+		t.Error("expected the migrated secret_key entry to be seal wrapped")
+	}
+
+	// A second read should not need to migrate anything again, and should
+	// still return the same secret key.
+	result2, err := b.clientConfigEntryInternal(s)
+	if err != nil {
+		t.Fatalf("second clientConfigEntryInternal call returned an error: %v", err)
+	}
+	if result2.SecretKey != "plaintext-secret" {
+		t.Errorf("SecretKey on second read = %q, want %q", result2.SecretKey, "plaintext-secret")
+	}
+}
+
+func TestClientConfigEntryInternalMigratesPlaintextVaultToken(t *testing.T) {
+	b := &backend{}
+	s := &logical.InmemStorage{}
+
+	legacy, err := logical.StorageEntryJSON("config/client", map[string]interface{}{
+		"vault_aws_engine_mount": "aws/",
+		"vault_token":            "plaintext-token",
+	})
+	if err != nil {
+		t.Fatalf("failed to build legacy storage entry: %v", err)
+	}
+	if err := s.Put(legacy); err != nil {
+		t.Fatalf("failed to write legacy storage entry: %v", err)
+	}
+
+	result, err := b.clientConfigEntryInternal(s)
+	if err != nil {
+		t.Fatalf("clientConfigEntryInternal returned an error: %v", err)
+	}
+	if result.VaultToken != "plaintext-token" {
+		t.Errorf("VaultToken = %q, want the migrated legacy value", result.VaultToken)
+	}
+
+	// The plaintext config/client entry should no longer carry vault_token...
+	raw, err := s.Get("config/client")
+	if err != nil {
+		t.Fatalf("failed to re-read config/client: %v", err)
+	}
+	var onDisk map[string]interface{}
+	if err := raw.DecodeJSON(&onDisk); err != nil {
+		t.Fatalf("failed to decode config/client: %v", err)
+	}
+	if _, present := onDisk["vault_token"]; present {
+		t.Error("vault_token is still present in the plaintext config/client entry after migration")
+	}
+
+	// ...and it should now be readable back from the seal-wrapped entry.
+	sealWrapped, err := s.Get("config/client/vault_token")
+	if err != nil {
+		t.Fatalf("failed to read seal-wrapped vault_token entry: %v", err)
+	}
+	if sealWrapped == nil {
+		t.Fatal("expected a seal-wrapped config/client/vault_token entry to have been written")
+	}
+	if !sealWrapped.SealWrap {
+		t.Error("expected the migrated vault_token entry to be seal wrapped")
+	}
+}
+
+func TestPathConfigClientReadRedactsVaultToken(t *testing.T) {
+	b := &backend{}
+	s := &logical.InmemStorage{}
+
+	if err := b.storeVaultToken(s, "s.topsecret"); err != nil {
+		t.Fatalf("storeVaultToken returned an error: %v", err)
+	}
+	entry, err := logical.StorageEntryJSON("config/client", &clientConfig{VaultAWSEngineMount: "aws/"})
+	if err != nil {
+		t.Fatalf("failed to build config/client entry: %v", err)
+	}
+	if err := s.Put(entry); err != nil {
+		t.Fatalf("failed to write config/client entry: %v", err)
+	}
+
+	req := &logical.Request{Storage: s}
+	resp, err := b.pathConfigClientRead(req, nil)
+	if err != nil {
+		t.Fatalf("pathConfigClientRead returned an error: %v", err)
+	}
+	if got := resp.Data["vault_token"]; got != "<sensitive>" {
+		t.Errorf("vault_token in read response = %v, want the redacted sentinel", got)
+	}
+}
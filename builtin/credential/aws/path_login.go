@@ -0,0 +1,407 @@
+package aws
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// pathLogin handles the STS GetCallerIdentity based login flow
+// (auth_type=iam), which lets any workload holding AWS credentials - not
+// just an EC2 instance - authenticate. auth_type=ec2 (PKCS7-signed instance
+// identity document) is not yet implemented; see pathLoginUpdate.
+func pathLogin(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "login$",
+		Fields: map[string]*framework.FieldSchema{
+			"role": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "Name of the role to authenticate as. Defaults to the principal's own name (the last path segment of its ARN, or the role name for an assumed role).",
+			},
+
+			"auth_type": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "iam",
+				Description: `Login method to use. Currently only "iam" (pre-signed sts:GetCallerIdentity request) is supported.`,
+			},
+
+			"iam_http_request_method": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "POST",
+				Description: "HTTP method used in the signed sts:GetCallerIdentity request, for auth_type=iam.",
+			},
+
+			"iam_request_url": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "Base64-encoded URL of the signed sts:GetCallerIdentity request, for auth_type=iam.",
+			},
+
+			"iam_request_body": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "Base64-encoded body of the signed sts:GetCallerIdentity request, for auth_type=iam.",
+			},
+
+			"iam_request_headers": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "Base64-encoded JSON object of the headers of the signed sts:GetCallerIdentity request, for auth_type=iam.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathLoginUpdate,
+		},
+
+		HelpSynopsis:    pathLoginHelpSyn,
+		HelpDescription: pathLoginHelpDesc,
+	}
+}
+
+func (b *backend) pathLoginUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	authType := data.Get("auth_type").(string)
+
+	switch authType {
+	case "", "iam":
+		return b.pathLoginIam(req, data)
+	case "ec2":
+		return logical.ErrorResponse("auth_type=ec2 (instance identity document) login is not implemented; use auth_type=iam"), nil
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unrecognized auth_type %q", authType)), nil
+	}
+}
+
+// pathLoginIam validates a pre-signed sts:GetCallerIdentity request by
+// replaying it against STS, reading back the caller's ARN from the response,
+// and matching it against the bound_iam_principal_arn entries of the
+// requested role.
+func (b *backend) pathLoginIam(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	method := data.Get("iam_http_request_method").(string)
+
+	rawURL, err := base64.StdEncoding.DecodeString(data.Get("iam_request_url").(string))
+	if err != nil {
+		return logical.ErrorResponse("iam_request_url is not valid base64"), nil
+	}
+
+	rawBody, err := base64.StdEncoding.DecodeString(data.Get("iam_request_body").(string))
+	if err != nil {
+		return logical.ErrorResponse("iam_request_body is not valid base64"), nil
+	}
+
+	rawHeaders, err := base64.StdEncoding.DecodeString(data.Get("iam_request_headers").(string))
+	if err != nil {
+		return logical.ErrorResponse("iam_request_headers is not valid base64"), nil
+	}
+
+	var headers map[string][]string
+	if err := json.Unmarshal(rawHeaders, &headers); err != nil {
+		return logical.ErrorResponse("iam_request_headers is not a valid JSON object of header name to values"), nil
+	}
+
+	if err := validateGetCallerIdentityRequest(string(rawBody)); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	clientConfig, err := b.clientConfigEntry(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if clientConfig != nil && clientConfig.IAMServerIDHeaderValue != "" {
+		if !headerValueMatches(headers, "X-Vault-AWS-IAM-Server-ID", clientConfig.IAMServerIDHeaderValue) {
+			return logical.ErrorResponse("missing or incorrect X-Vault-AWS-IAM-Server-ID header"), nil
+		}
+	}
+
+	httpReq, err := http.NewRequest(method, string(rawURL), bytes.NewReader(rawBody))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to construct sts:GetCallerIdentity request: %v", err)), nil
+	}
+	for name, values := range headers {
+		for _, value := range values {
+			httpReq.Header.Add(name, value)
+		}
+	}
+
+	// A backend that has never had config/client written (a normal state for
+	// a mount that only ever does auth_type=iam login) must not be treated as
+	// "no allowlist configured, so allow any endpoint" - fall back to the
+	// same default config/client itself would use.
+	allowedSTSEndpoints := defaultAllowedSTSEndpoints
+	if clientConfig != nil {
+		allowedSTSEndpoints = clientConfig.AllowedSTSEndpoints
+	}
+
+	if len(allowedSTSEndpoints) > 0 {
+		allowed := false
+		for _, endpoint := range allowedSTSEndpoints {
+			if strings.EqualFold(httpReq.URL.Hostname(), endpoint) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return logical.ErrorResponse(fmt.Sprintf("STS endpoint %q is not in allowed_sts_endpoints", httpReq.URL.Hostname())), nil
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to call STS: %v", err)), nil
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return logical.ErrorResponse(fmt.Sprintf("STS returned %d: %s", resp.StatusCode, respBody)), nil
+	}
+
+	identity, err := parseGetCallerIdentityResponse(respBody)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("could not parse STS response: %v", err)), nil
+	}
+
+	roleName := data.Get("role").(string)
+	if roleName == "" {
+		roleName = identity.canonicalPrincipalName()
+	}
+
+	roleEntry, err := b.role(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if roleEntry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %q not found", roleName)), nil
+	}
+
+	if roleEntry.BoundAccountID != "" && roleEntry.BoundAccountID != identity.Account {
+		return logical.ErrorResponse("account ID does not match bound_account_id"), nil
+	}
+
+	canonicalARN := identity.canonicalPrincipalARN()
+	if !arnMatchesAnyBoundPrincipal(canonicalARN, roleEntry.BoundIAMPrincipalARNs) {
+		return logical.ErrorResponse(fmt.Sprintf("ARN %q does not match any bound_iam_principal_arn for role %q", canonicalARN, roleName)), nil
+	}
+
+	if roleEntry.ResolveAWSUniqueIDs {
+		if err := b.verifyOrPinUniqueID(req.Storage, roleName, canonicalARN, identity.uniqueID()); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	return &logical.Response{
+		Auth: &logical.Auth{
+			Policies: roleEntry.Policies,
+			Metadata: map[string]string{
+				"role":          roleName,
+				"auth_type":     "iam",
+				"client_arn":    identity.Arn,
+				"canonical_arn": canonicalARN,
+				"account_id":    identity.Account,
+			},
+			LeaseOptions: logical.LeaseOptions{
+				TTL:       durationFromSeconds(roleEntry.TTL),
+				MaxTTL:    durationFromSeconds(roleEntry.MaxTTL),
+				Renewable: true,
+			},
+		},
+	}, nil
+}
+
+// verifyOrPinUniqueID pins the AWS unique ID (the portion of the STS UserId
+// preceding the ":" for an assumed role, or the UserId itself otherwise) seen
+// on the first successful login for a role/principal pair, and rejects later
+// logins whose unique ID has changed - e.g. because the IAM role was deleted
+// and recreated with the same name. The pin is keyed by the canonical
+// principal ARN, not just the role, since bound_iam_principal_arn (including
+// its wildcard form) commonly matches more than one distinct principal, and
+// each must be pinned independently so that one principal's first login
+// doesn't lock the others out.
+func (b *backend) verifyOrPinUniqueID(s logical.Storage, roleName, canonicalARN, uniqueID string) error {
+	if uniqueID == "" {
+		return nil
+	}
+
+	key := "login/uniqueid/" + roleName + "/" + canonicalARN
+	entry, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		pinned, err := logical.StorageEntryJSON(key, uniqueID)
+		if err != nil {
+			return err
+		}
+		return s.Put(pinned)
+	}
+
+	var pinnedID string
+	if err := entry.DecodeJSON(&pinnedID); err != nil {
+		return err
+	}
+	if pinnedID != uniqueID {
+		return fmt.Errorf("unique ID %q does not match the one pinned for principal %q on role %q on first login", uniqueID, canonicalARN, roleName)
+	}
+	return nil
+}
+
+// validateGetCallerIdentityRequest rejects a request body that does not
+// represent an sts:GetCallerIdentity call, so that the forwarded request
+// cannot be repurposed to make Vault issue some other signed AWS API call on
+// the caller's behalf, and so a forged response can't be claimed to answer a
+// request that was never actually GetCallerIdentity.
+func validateGetCallerIdentityRequest(rawBody string) error {
+	bodyValues, err := url.ParseQuery(rawBody)
+	if err != nil {
+		return fmt.Errorf("iam_request_body could not be parsed as a form-encoded STS request: %v", err)
+	}
+	if bodyValues.Get("Action") != "GetCallerIdentity" {
+		return fmt.Errorf("iam_request_body does not represent an sts:GetCallerIdentity call")
+	}
+	return nil
+}
+
+func headerValueMatches(headers map[string][]string, name, value string) bool {
+	for headerName, values := range headers {
+		if !strings.EqualFold(headerName, name) {
+			continue
+		}
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// arnMatchesAnyBoundPrincipal checks arn against each bound entry, honoring a
+// trailing "*" as a prefix-match wildcard.
+func arnMatchesAnyBoundPrincipal(arn string, bound []string) bool {
+	for _, candidate := range bound {
+		if strings.HasSuffix(candidate, "*") {
+			if strings.HasPrefix(arn, strings.TrimSuffix(candidate, "*")) {
+				return true
+			}
+			continue
+		}
+		if arn == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// getCallerIdentityResponse models the subset of the STS GetCallerIdentity
+// XML response that the login path cares about.
+type getCallerIdentityResponse struct {
+	XMLName xml.Name `xml:"GetCallerIdentityResponse"`
+	Result  struct {
+		Arn     string `xml:"Arn"`
+		UserId  string `xml:"UserId"`
+		Account string `xml:"Account"`
+	} `xml:"GetCallerIdentityResult"`
+}
+
+type callerIdentity struct {
+	Arn     string
+	UserId  string
+	Account string
+}
+
+func parseGetCallerIdentityResponse(body []byte) (*callerIdentity, error) {
+	var parsed getCallerIdentityResponse
+	if err := xml.Unmarshal(body, &parsed); err != nil {
+		return nil, err
+	}
+	if parsed.Result.Arn == "" {
+		return nil, fmt.Errorf("response did not contain an Arn")
+	}
+	return &callerIdentity{
+		Arn:     parsed.Result.Arn,
+		UserId:  parsed.Result.UserId,
+		Account: parsed.Result.Account,
+	}, nil
+}
+
+// canonicalPrincipalARN rewrites an assumed-role ARN
+// (arn:aws:sts::ACCOUNT:assumed-role/ROLE/SESSION) into the IAM role ARN
+// (arn:aws:iam::ACCOUNT:role/ROLE) it was assumed from, so that it can be
+// matched against bound_iam_principal_arn entries written against the role
+// itself rather than against every possible session name. Other ARN types
+// (IAM user, federated user) are returned unchanged.
+func (c *callerIdentity) canonicalPrincipalARN() string {
+	parts := strings.Split(c.Arn, ":")
+	if len(parts) != 6 || !strings.HasPrefix(parts[5], "assumed-role/") {
+		return c.Arn
+	}
+
+	resourceParts := strings.Split(strings.TrimPrefix(parts[5], "assumed-role/"), "/")
+	if len(resourceParts) < 2 {
+		return c.Arn
+	}
+	roleName := resourceParts[0]
+
+	parts[2] = "iam"
+	parts[5] = "role/" + roleName
+	return strings.Join(parts, ":")
+}
+
+// canonicalPrincipalName derives a role-lookup key from the ARN when the
+// login request does not specify one explicitly.
+func (c *callerIdentity) canonicalPrincipalName() string {
+	arn := c.canonicalPrincipalARN()
+	idx := strings.LastIndex(arn, "/")
+	if idx == -1 {
+		return arn
+	}
+	return arn[idx+1:]
+}
+
+// uniqueID returns the portion of the STS UserId that identifies the
+// underlying IAM role or user, independent of the session name used for an
+// assumed role.
+func (c *callerIdentity) uniqueID() string {
+	if idx := strings.Index(c.UserId, ":"); idx != -1 {
+		return c.UserId[:idx]
+	}
+	return c.UserId
+}
+
+// durationFromSeconds converts a seconds value from role configuration into a
+// time.Duration, leaving a zero value (meaning "use the backend default") as
+// zero.
+func durationFromSeconds(seconds int) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+const pathLoginHelpSyn = `
+Authenticate using a pre-signed STS GetCallerIdentity request.
+`
+
+const pathLoginHelpDesc = `
+This path allows any AWS IAM principal holding credentials (IAM users, assumed roles,
+EC2 instance profiles, Lambda, ECS tasks, and so on) to authenticate and receive a Vault
+token. Set auth_type to "iam" and present a pre-signed sts:GetCallerIdentity request
+(method, URL, body and headers, each base64-encoded).
+`
@@ -0,0 +1,142 @@
+package aws
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func TestArnMatchesAnyBoundPrincipal(t *testing.T) {
+	bound := []string{
+		"arn:aws:iam::123456789012:role/exact-match",
+		"arn:aws:iam::123456789012:role/prefix-*",
+	}
+
+	cases := []struct {
+		arn   string
+		match bool
+	}{
+		{"arn:aws:iam::123456789012:role/exact-match", true},
+		{"arn:aws:iam::123456789012:role/prefix-anything", true},
+		{"arn:aws:iam::123456789012:role/prefix-", true},
+		{"arn:aws:iam::123456789012:role/other", false},
+		{"arn:aws:iam::999999999999:role/exact-match", false},
+	}
+
+	for _, c := range cases {
+		if got := arnMatchesAnyBoundPrincipal(c.arn, bound); got != c.match {
+			t.Errorf("arnMatchesAnyBoundPrincipal(%q) = %v, want %v", c.arn, got, c.match)
+		}
+	}
+}
+
+func TestCanonicalPrincipalARN(t *testing.T) {
+	cases := []struct {
+		arn  string
+		want string
+	}{
+		{
+			arn:  "arn:aws:sts::123456789012:assumed-role/my-role/my-session",
+			want: "arn:aws:iam::123456789012:role/my-role",
+		},
+		{
+			arn:  "arn:aws:iam::123456789012:user/my-user",
+			want: "arn:aws:iam::123456789012:user/my-user",
+		},
+		{
+			arn:  "not-an-arn",
+			want: "not-an-arn",
+		},
+	}
+
+	for _, c := range cases {
+		identity := &callerIdentity{Arn: c.arn}
+		if got := identity.canonicalPrincipalARN(); got != c.want {
+			t.Errorf("canonicalPrincipalARN(%q) = %q, want %q", c.arn, got, c.want)
+		}
+	}
+}
+
+func TestVerifyOrPinUniqueIDPerPrincipal(t *testing.T) {
+	b := &backend{}
+	s := &logical.InmemStorage{}
+
+	principalA := "arn:aws:iam::123456789012:role/prefix-a"
+	principalB := "arn:aws:iam::123456789012:role/prefix-b"
+
+	// Two distinct principals matched by the same wildcarded
+	// bound_iam_principal_arn must each be able to pin and log in with their
+	// own unique ID, without one locking out the other.
+	if err := b.verifyOrPinUniqueID(s, "role1", principalA, "unique-a"); err != nil {
+		t.Fatalf("first login for principal A returned an error: %v", err)
+	}
+	if err := b.verifyOrPinUniqueID(s, "role1", principalB, "unique-b"); err != nil {
+		t.Fatalf("first login for principal B returned an error: %v", err)
+	}
+
+	// Repeat logins with the same unique ID succeed for both principals.
+	if err := b.verifyOrPinUniqueID(s, "role1", principalA, "unique-a"); err != nil {
+		t.Errorf("second login for principal A returned an error: %v", err)
+	}
+	if err := b.verifyOrPinUniqueID(s, "role1", principalB, "unique-b"); err != nil {
+		t.Errorf("second login for principal B returned an error: %v", err)
+	}
+
+	// A changed unique ID for one principal (e.g. a deleted and recreated
+	// role) is rejected, and does not affect the other principal's pin.
+	if err := b.verifyOrPinUniqueID(s, "role1", principalA, "different-unique-id"); err == nil {
+		t.Error("expected a changed unique ID for principal A to be rejected")
+	}
+	if err := b.verifyOrPinUniqueID(s, "role1", principalB, "unique-b"); err != nil {
+		t.Errorf("principal B login was affected by principal A's pin mismatch: %v", err)
+	}
+}
+
+func TestPathLoginIamAppliesDefaultAllowlistWithoutConfigClient(t *testing.T) {
+	b := &backend{}
+	s := &logical.InmemStorage{}
+
+	// config/client has never been written, so clientConfigEntry returns a
+	// nil *clientConfig - the allowed_sts_endpoints check must still apply
+	// the same default as config/client would, rather than allowing any
+	// endpoint.
+	req := &logical.Request{Storage: s}
+	raw := map[string]interface{}{
+		"role":                    "",
+		"auth_type":               "iam",
+		"iam_http_request_method": "POST",
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte("https://sts.evil.example.com/")),
+		"iam_request_body":        base64.StdEncoding.EncodeToString([]byte("Action=GetCallerIdentity&Version=2011-06-15")),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString([]byte("{}")),
+	}
+	data := &framework.FieldData{Raw: raw, Schema: pathLogin(b).Fields}
+
+	resp, err := b.pathLoginIam(req, data)
+	if err != nil {
+		t.Fatalf("pathLoginIam returned an error: %v", err)
+	}
+	if resp == nil || resp.Data == nil {
+		t.Fatal("expected an error response rejecting the disallowed STS endpoint")
+	}
+	errMsg, _ := resp.Data["error"].(string)
+	if !strings.Contains(errMsg, "not in allowed_sts_endpoints") {
+		t.Errorf("error = %q, want it to mention allowed_sts_endpoints", errMsg)
+	}
+}
+
+func TestValidateGetCallerIdentityRequest(t *testing.T) {
+	if err := validateGetCallerIdentityRequest("Action=GetCallerIdentity&Version=2011-06-15"); err != nil {
+		t.Errorf("expected a genuine GetCallerIdentity body to validate, got: %v", err)
+	}
+
+	if err := validateGetCallerIdentityRequest("Action=DescribeInstances&Version=2016-11-15"); err == nil {
+		t.Error("expected a non-GetCallerIdentity action to be rejected")
+	}
+
+	if err := validateGetCallerIdentityRequest(""); err == nil {
+		t.Error("expected an empty body to be rejected")
+	}
+}
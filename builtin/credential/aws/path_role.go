@@ -0,0 +1,227 @@
+package aws
+
+import (
+	"github.com/fatih/structs"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// pathRole registers the set of IAM/EC2 principals a login is allowed to
+// authenticate as, along with the policies granted to a successful login.
+func pathRole(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("role"),
+		Fields: map[string]*framework.FieldSchema{
+			"role": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+			},
+
+			"bound_iam_principal_arn": &framework.FieldSchema{
+				Type:    framework.TypeCommaStringSlice,
+				Default: []string{},
+				Description: `Comma-separated list of IAM principal ARNs that are allowed to
+authenticate as this role via auth_type=iam. A trailing "*" matches any suffix, e.g.
+"arn:aws:iam::123456789012:role/*" matches any role in that account. Required for
+auth_type=iam logins.`,
+			},
+
+			"bound_account_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "If set, only allows login from this AWS account ID.",
+			},
+
+			"resolve_aws_unique_ids": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: true,
+				Description: `If set, the AWS unique ID of the principal that successfully logs in as
+this role is pinned, on that first login, so that a subsequently deleted and recreated principal
+of the same name can no longer authenticate as this role. The pin is tracked per principal
+ARN, so distinct principals matched by different (or wildcarded) bound ARNs on the same role
+are pinned, and can log in, independently of one another.`,
+			},
+
+			"policies": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Default:     []string{},
+				Description: "Comma-separated list of policies granted to tokens issued by this role.",
+			},
+
+			"ttl": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Default:     0,
+				Description: "Duration in seconds for the returned token's lease.",
+			},
+
+			"max_ttl": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Default:     0,
+				Description: "Maximum duration in seconds the returned token can be renewed for.",
+			},
+		},
+
+		ExistenceCheck: b.pathRoleExistenceCheck,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathRoleCreateUpdate,
+			logical.UpdateOperation: b.pathRoleCreateUpdate,
+			logical.ReadOperation:   b.pathRoleRead,
+			logical.DeleteOperation: b.pathRoleDelete,
+		},
+
+		HelpSynopsis:    pathRoleHelpSyn,
+		HelpDescription: pathRoleHelpDesc,
+	}
+}
+
+func pathListRoles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/?",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathRoleList,
+		},
+
+		HelpSynopsis:    pathRoleHelpSyn,
+		HelpDescription: pathRoleHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleExistenceCheck(
+	req *logical.Request, data *framework.FieldData) (bool, error) {
+	entry, err := b.role(req.Storage, data.Get("role").(string))
+	if err != nil {
+		return false, err
+	}
+	return entry != nil, nil
+}
+
+func (b *backend) pathRoleList(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roles, err := req.Storage.List("role/")
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(roles), nil
+}
+
+func (b *backend) role(s logical.Storage, name string) (*awsRoleEntry, error) {
+	entry, err := s.Get("role/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result awsRoleEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (b *backend) pathRoleRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleEntry, err := b.role(req.Storage, data.Get("role").(string))
+	if err != nil {
+		return nil, err
+	}
+	if roleEntry == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: structs.New(roleEntry).Map(),
+	}, nil
+}
+
+func (b *backend) pathRoleDelete(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return nil, req.Storage.Delete("role/" + data.Get("role").(string))
+}
+
+func (b *backend) pathRoleCreateUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+
+	roleEntry, err := b.role(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if roleEntry == nil {
+		roleEntry = &awsRoleEntry{}
+	}
+
+	if principalsRaw, ok := data.GetOk("bound_iam_principal_arn"); ok {
+		roleEntry.BoundIAMPrincipalARNs = principalsRaw.([]string)
+	} else if req.Operation == logical.CreateOperation {
+		roleEntry.BoundIAMPrincipalARNs = data.Get("bound_iam_principal_arn").([]string)
+	}
+
+	if boundAccountIDStr, ok := data.GetOk("bound_account_id"); ok {
+		roleEntry.BoundAccountID = boundAccountIDStr.(string)
+	} else if req.Operation == logical.CreateOperation {
+		roleEntry.BoundAccountID = data.Get("bound_account_id").(string)
+	}
+
+	if resolveUniqueIDsBool, ok := data.GetOk("resolve_aws_unique_ids"); ok {
+		roleEntry.ResolveAWSUniqueIDs = resolveUniqueIDsBool.(bool)
+	} else if req.Operation == logical.CreateOperation {
+		roleEntry.ResolveAWSUniqueIDs = data.Get("resolve_aws_unique_ids").(bool)
+	}
+
+	if policiesRaw, ok := data.GetOk("policies"); ok {
+		roleEntry.Policies = policiesRaw.([]string)
+	} else if req.Operation == logical.CreateOperation {
+		roleEntry.Policies = data.Get("policies").([]string)
+	}
+
+	if ttlInt, ok := data.GetOk("ttl"); ok {
+		roleEntry.TTL = ttlInt.(int)
+	} else if req.Operation == logical.CreateOperation {
+		roleEntry.TTL = data.Get("ttl").(int)
+	}
+
+	if maxTTLInt, ok := data.GetOk("max_ttl"); ok {
+		roleEntry.MaxTTL = maxTTLInt.(int)
+	} else if req.Operation == logical.CreateOperation {
+		roleEntry.MaxTTL = data.Get("max_ttl").(int)
+	}
+
+	if len(roleEntry.BoundIAMPrincipalARNs) == 0 {
+		return logical.ErrorResponse("at least one bound_iam_principal_arn must be set"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON("role/"+roleName, roleEntry)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// awsRoleEntry stores the IAM/EC2 principals allowed to log in as a role, and
+// the policies that login is granted.
+type awsRoleEntry struct {
+	BoundIAMPrincipalARNs []string `json:"bound_iam_principal_arn" structs:"bound_iam_principal_arn" mapstructure:"bound_iam_principal_arn"`
+	BoundAccountID        string   `json:"bound_account_id" structs:"bound_account_id" mapstructure:"bound_account_id"`
+	ResolveAWSUniqueIDs   bool     `json:"resolve_aws_unique_ids" structs:"resolve_aws_unique_ids" mapstructure:"resolve_aws_unique_ids"`
+	Policies              []string `json:"policies" structs:"policies" mapstructure:"policies"`
+	TTL                   int      `json:"ttl" structs:"ttl" mapstructure:"ttl"`
+	MaxTTL                int      `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
+}
+
+const pathRoleHelpSyn = `
+Manage the roles that can be used to authenticate with this backend.
+`
+
+const pathRoleHelpDesc = `
+A role binds a set of AWS IAM principals to a set of Vault policies. A login
+(auth_type=iam) presenting credentials for a bound principal is granted a token
+with the role's policies. auth_type=ec2 logins are rejected.
+`